@@ -0,0 +1,225 @@
+// Package otlpwrite implements an alternative output transport that
+// exports k6 metrics as native OpenTelemetry metrics (OTLP), instead of
+// translating them into the Prometheus Remote-Write wire format.
+package otlpwrite
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// Transport selects how the OTLP payload is delivered to the collector.
+type Transport string
+
+const (
+	// TransportHTTP posts the OTLP protobuf payload to the endpoint's
+	// /v1/metrics path.
+	TransportHTTP Transport = "http"
+	// TransportGRPC sends the payload over the OTLP/gRPC metrics service.
+	TransportGRPC Transport = "grpc"
+)
+
+// ClientConfig configures a Client.
+type ClientConfig struct {
+	Endpoint  string
+	Transport Transport
+	Headers   map[string]string
+	Timeout   time.Duration
+	// Insecure disables TLS on the gRPC transport, dialing in plaintext
+	// instead. It only applies when Transport is TransportGRPC; HTTP
+	// always uses whatever scheme Endpoint specifies.
+	Insecure bool
+}
+
+// Client pushes pmetric.Metrics payloads to an OTLP endpoint.
+type Client struct {
+	config     ClientConfig
+	httpClient *http.Client
+	grpcConn   *grpc.ClientConn
+}
+
+// NewClient creates a Client for the given OTLP endpoint and transport.
+func NewClient(cfg ClientConfig) (*Client, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("an OTLP endpoint is required")
+	}
+	c := &Client{config: cfg}
+	switch cfg.Transport {
+	case "", TransportHTTP:
+		c.config.Transport = TransportHTTP
+		c.httpClient = &http.Client{Timeout: cfg.Timeout}
+	case TransportGRPC:
+		// grpc.NewClient requires an explicit transport credentials dial
+		// option. Real OTLP vendors (Grafana Cloud, Honeycomb, etc.)
+		// terminate TLS, so that's the default; Insecure opts into
+		// plaintext for a local collector.
+		creds := credentials.NewTLS(&tls.Config{})
+		if cfg.Insecure {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.NewClient(cfg.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial the OTLP gRPC endpoint: %w", err)
+		}
+		c.grpcConn = conn
+	default:
+		return nil, fmt.Errorf("unsupported OTLP transport %q", cfg.Transport)
+	}
+	return c, nil
+}
+
+// Export sends the given metrics payload to the configured endpoint.
+func (c *Client) Export(ctx context.Context, metrics pmetric.Metrics) error {
+	req := pmetricotlp.NewExportRequestFromMetrics(metrics)
+
+	switch c.config.Transport {
+	case TransportGRPC:
+		if len(c.config.Headers) > 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, headerPairs(c.config.Headers)...)
+		}
+		client := pmetricotlp.NewGRPCClient(c.grpcConn)
+		_, err := client.Export(ctx, req)
+		return err
+	default:
+		b, err := req.MarshalProto()
+		if err != nil {
+			return fmt.Errorf("failed to marshal the OTLP export request: %w", err)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.Endpoint+"/v1/metrics", bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("failed to create the OTLP request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/x-protobuf")
+		for k, v := range c.config.Headers {
+			httpReq.Header.Set(k, v)
+		}
+		resp, err := c.httpClient.Do(httpReq)
+		if err != nil {
+			return fmt.Errorf("failed to send the OTLP request: %w", err)
+		}
+		defer resp.Body.Close() //nolint:errcheck
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("OTLP endpoint returned status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// headerPairs flattens a header map into the alternating key/value form
+// metadata.AppendToOutgoingContext expects.
+func headerPairs(headers map[string]string) []string {
+	pairs := make([]string, 0, len(headers)*2)
+	for k, v := range headers {
+		pairs = append(pairs, k, v)
+	}
+	return pairs
+}
+
+// Builder accumulates k6 metric samples into a single pmetric.Metrics
+// payload, one ScopeMetrics per flush, with a shared Resource.
+type Builder struct {
+	metrics      pmetric.Metrics
+	scopeMetrics pmetric.ScopeMetrics
+}
+
+// NewBuilder creates a Builder, stamping the given resource attributes
+// (e.g. service.name=k6, k6.test.run_id) on the single Resource it emits.
+func NewBuilder(resourceAttrs map[string]string) *Builder {
+	m := pmetric.NewMetrics()
+	rm := m.ResourceMetrics().AppendEmpty()
+	attrs := rm.Resource().Attributes()
+	for k, v := range resourceAttrs {
+		attrs.PutStr(k, v)
+	}
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("xk6-output-prometheus-remote/otlpwrite")
+
+	return &Builder{metrics: m, scopeMetrics: sm}
+}
+
+// Build returns the accumulated payload.
+func (b *Builder) Build() pmetric.Metrics {
+	return b.metrics
+}
+
+func (b *Builder) newMetric(name string) pmetric.Metric {
+	m := b.scopeMetrics.Metrics().AppendEmpty()
+	m.SetName(name)
+	return m
+}
+
+// AddSum appends a monotonic, cumulative Sum data point, used for k6
+// Counter metrics.
+func (b *Builder) AddSum(name string, value float64, t time.Time, attrs map[string]string) {
+	m := b.newMetric(name)
+	sum := m.SetEmptySum()
+	sum.SetIsMonotonic(true)
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	dp := sum.DataPoints().AppendEmpty()
+	dp.SetDoubleValue(value)
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(t))
+	setAttrs(dp.Attributes(), attrs)
+}
+
+// AddGauge appends a Gauge data point, used for k6 Gauge and Rate metrics.
+func (b *Builder) AddGauge(name string, value float64, t time.Time, attrs map[string]string) {
+	m := b.newMetric(name)
+	dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.SetDoubleValue(value)
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(t))
+	setAttrs(dp.Attributes(), attrs)
+}
+
+// AddSummary appends a Summary data point with the given quantile values,
+// used to map k6 Trend metrics when native histograms are disabled.
+func (b *Builder) AddSummary(name string, sum float64, count uint64, quantiles map[float64]float64, t time.Time, attrs map[string]string) {
+	m := b.newMetric(name)
+	dp := m.SetEmptySummary().DataPoints().AppendEmpty()
+	dp.SetSum(sum)
+	dp.SetCount(count)
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(t))
+	setAttrs(dp.Attributes(), attrs)
+
+	qv := dp.QuantileValues()
+	for q, v := range quantiles {
+		e := qv.AppendEmpty()
+		e.SetQuantile(q)
+		e.SetValue(v)
+	}
+}
+
+// AddExponentialHistogram appends a base-2 exponential histogram data
+// point, used to map k6 Trend metrics when TrendAsNativeHistogram is set,
+// reusing the same bucket boundaries as the Prometheus native histogram sink.
+func (b *Builder) AddExponentialHistogram(name string, scale int32, zeroCount uint64, positiveOffset int32, positiveBuckets []uint64, sum float64, count uint64, t time.Time, attrs map[string]string) {
+	m := b.newMetric(name)
+	eh := m.SetEmptyExponentialHistogram()
+	eh.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	dp := eh.DataPoints().AppendEmpty()
+	dp.SetScale(scale)
+	dp.SetZeroCount(zeroCount)
+	dp.SetSum(sum)
+	dp.SetCount(count)
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(t))
+	dp.Positive().SetOffset(positiveOffset)
+	dp.Positive().BucketCounts().FromRaw(positiveBuckets)
+	setAttrs(dp.Attributes(), attrs)
+}
+
+func setAttrs(m pcommon.Map, attrs map[string]string) {
+	for k, v := range attrs {
+		m.PutStr(k, v)
+	}
+}
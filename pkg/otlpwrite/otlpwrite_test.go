@@ -0,0 +1,70 @@
+package otlpwrite
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"google.golang.org/grpc"
+)
+
+// TestAddExponentialHistogramSetsCumulativeTemporality is a regression test
+// for a chunk0-2 bug: unlike AddSum, AddExponentialHistogram never called
+// SetAggregationTemporality, leaving it at the default UNSPECIFIED value
+// that most OTLP backends reject or silently drop.
+func TestAddExponentialHistogramSetsCumulativeTemporality(t *testing.T) {
+	b := NewBuilder(map[string]string{"service.name": "k6"})
+	b.AddExponentialHistogram("test_trend", 2, 0, 0, []uint64{1, 2, 3}, 6, 3, time.Now(), nil)
+
+	got := b.Build()
+	m := got.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0)
+	if m.Type() != pmetric.MetricTypeExponentialHistogram {
+		t.Fatalf("expected an ExponentialHistogram metric, got %s", m.Type())
+	}
+	if temp := m.ExponentialHistogram().AggregationTemporality(); temp != pmetric.AggregationTemporalityCumulative {
+		t.Fatalf("expected cumulative aggregation temporality, got %s", temp)
+	}
+}
+
+// TestNewClientGRPCTransportDials is a regression test for a chunk0-2 bug:
+// NewClient called grpc.NewClient without a transport credentials dial
+// option, which grpc-go rejects outright ("no transport security set"), so
+// a TransportGRPC client could never be constructed.
+func TestNewClientGRPCTransportDials(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close() //nolint:errcheck
+
+	srv := grpc.NewServer()
+	go srv.Serve(lis) //nolint:errcheck
+	defer srv.Stop()
+
+	c, err := NewClient(ClientConfig{Endpoint: lis.Addr().String(), Transport: TransportGRPC, Insecure: true})
+	if err != nil {
+		t.Fatalf("NewClient returned an error for the grpc transport: %v", err)
+	}
+	if c.grpcConn == nil {
+		t.Fatalf("expected NewClient to set up a grpc connection")
+	}
+}
+
+// TestHeaderPairsFlattensToAlternatingKeyValue is a regression test for a
+// chunk0-2 bug: the gRPC Export path never attached c.config.Headers to
+// the outgoing call at all, unlike the HTTP path. headerPairs is what
+// turns the header map into the alternating key/value slice
+// metadata.AppendToOutgoingContext requires.
+func TestHeaderPairsFlattensToAlternatingKeyValue(t *testing.T) {
+	got := headerPairs(map[string]string{"x-api-key": "secret"})
+	want := []string{"x-api-key", "secret"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if got := headerPairs(nil); len(got) != 0 {
+		t.Fatalf("expected no pairs for an empty header map, got %v", got)
+	}
+}
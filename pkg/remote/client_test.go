@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	prompb "go.buf.build/grpc/go/prometheus/prometheus"
+)
+
+// TestNewWriteClientInsecureSkipTLSVerify is a regression test for a
+// chunk0-1 bug: HTTPClientConfig.InsecureSkipTLSVerify was parsed and
+// threaded all the way into this config but never actually applied to the
+// client's transport, so it had no effect on a self-signed endpoint.
+func TestNewWriteClientInsecureSkipTLSVerify(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	series := []*prompb.TimeSeries{{Labels: []*prompb.Label{{Name: "__name__", Value: "test"}}}}
+
+	insecure, err := NewWriteClient(srv.URL, HTTPClientConfig{InsecureSkipTLSVerify: true})
+	if err != nil {
+		t.Fatalf("failed to build the client: %v", err)
+	}
+	if err := insecure.Store(context.Background(), series); err != nil {
+		t.Fatalf("expected InsecureSkipTLSVerify to let the client talk to a self-signed endpoint, got: %v", err)
+	}
+
+	verifying, err := NewWriteClient(srv.URL, HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build the client: %v", err)
+	}
+	if err := verifying.Store(context.Background(), series); err == nil {
+		t.Fatal("expected a TLS verification error against a self-signed endpoint when InsecureSkipTLSVerify is unset")
+	}
+}
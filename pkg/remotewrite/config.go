@@ -0,0 +1,348 @@
+package remotewrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/xk6-output-prometheus-remote/pkg/remote"
+	"go.k6.io/k6/lib/types"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Remote Write protocol versions supported by the Config.RemoteWriteProtocol option.
+const (
+	// RemoteWriteProtocolV1 is the original Prometheus Remote-Write 1.0 protobuf protocol.
+	RemoteWriteProtocolV1 = "prw1"
+	// RemoteWriteProtocolV2 is the Prometheus Remote-Write 2.0 protocol.
+	RemoteWriteProtocolV2 = "prw2"
+)
+
+// Output transports supported by the Config.Protocol option.
+const (
+	// ProtocolRemoteWrite translates samples into the Prometheus
+	// Remote-Write wire format (the default).
+	ProtocolRemoteWrite = "remote_write"
+	// ProtocolOTLP exports samples as native OTLP metrics instead.
+	ProtocolOTLP = "otlp"
+)
+
+// Config is the config for the remote write output.
+type Config struct {
+	// Only for testing purpose
+	ConfigOverride null.Bool `json:"-"`
+
+	URL                   null.String       `json:"url" envconfig:"K6_PROMETHEUS_RW_SERVER_URL"`
+	InsecureSkipTLSVerify null.Bool         `json:"insecureSkipTLSVerify" envconfig:"K6_PROMETHEUS_RW_INSECURE_SKIP_TLS_VERIFY"`
+	Username              null.String       `json:"username" envconfig:"K6_PROMETHEUS_RW_USERNAME"`
+	Password              null.String       `json:"password" envconfig:"K6_PROMETHEUS_RW_PASSWORD"`
+	BearerToken           null.String       `json:"bearerToken" envconfig:"K6_PROMETHEUS_RW_BEARER_TOKEN"`
+	Headers               map[string]string `json:"headers,omitempty"`
+
+	PushInterval  types.NullDuration `json:"pushInterval" envconfig:"K6_PROMETHEUS_RW_PUSH_INTERVAL"`
+	ClientTimeout types.NullDuration `json:"clientTimeout" envconfig:"K6_PROMETHEUS_RW_CLIENT_TIMEOUT"`
+
+	TrendStats             []string  `json:"trendStats" envconfig:"K6_PROMETHEUS_RW_TREND_STATS"`
+	TrendAsNativeHistogram null.Bool `json:"trendAsNativeHistogram" envconfig:"K6_PROMETHEUS_RW_TREND_AS_NATIVE_HISTOGRAM"`
+
+	// MaxSampleAge drops series that haven't been updated more recently
+	// than this, instead of sending them, guarding against a delayed flush
+	// carrying samples old enough for the remote endpoint's out-of-order
+	// time window to reject the whole request. Zero (the default) disables
+	// the check.
+	MaxSampleAge types.NullDuration `json:"maxSampleAge" envconfig:"K6_PROMETHEUS_RW_MAX_SAMPLE_AGE"`
+
+	// BufferDir, when set, turns on durable buffering: each flush is
+	// appended to an on-disk, snappy-compressed write-ahead log under this
+	// directory before being sent, and a background goroutine drains it
+	// with retries, so a flaky endpoint doesn't lose a flush window. It only
+	// applies to the prw1 Remote-Write path: with RemoteWriteProtocol set to
+	// prw2, or with Protocol set to otlp, flushes are sent inline and are not
+	// buffered or retried.
+	BufferDir null.String `json:"bufferDir" envconfig:"K6_PROMETHEUS_RW_BUFFER_DIR"`
+	// MaxBufferBytes caps the WAL's on-disk size; once exceeded, the
+	// oldest buffered segments are evicted to make room for new ones.
+	MaxBufferBytes null.Int `json:"maxBufferBytes" envconfig:"K6_PROMETHEUS_RW_MAX_BUFFER_BYTES"`
+
+	// RemoteWriteProtocol selects the wire protocol used to talk to the remote
+	// write endpoint: "prw1" (default, Remote-Write 1.0) or "prw2" (Remote-Write 2.0).
+	RemoteWriteProtocol null.String `json:"remoteWriteProtocol" envconfig:"K6_PROMETHEUS_RW_REMOTE_WRITE_PROTOCOL"`
+
+	// Protocol selects the output transport: "remote_write" (default) or
+	// "otlp". The OTLP-specific options below only apply when it's "otlp".
+	Protocol      null.String       `json:"protocol" envconfig:"K6_PROMETHEUS_RW_PROTOCOL"`
+	OTLPEndpoint  null.String       `json:"otlpEndpoint" envconfig:"K6_PROMETHEUS_RW_OTLP_ENDPOINT"`
+	OTLPTransport null.String       `json:"otlpTransport" envconfig:"K6_PROMETHEUS_RW_OTLP_TRANSPORT"`
+	OTLPHeaders   map[string]string `json:"otlpHeaders,omitempty"`
+	// OTLPInsecure disables TLS on the gRPC transport, dialing in
+	// plaintext instead. It only applies when OTLPTransport is "grpc"; the
+	// default (false) dials with real TLS credentials, as required by
+	// hosted OTLP vendors.
+	OTLPInsecure null.Bool `json:"otlpInsecure" envconfig:"K6_PROMETHEUS_RW_OTLP_INSECURE"`
+
+	// Endpoints, when non-empty, fans the same test run out to multiple
+	// named remote write targets instead of the single URL/auth/headers
+	// above, each with its own auth, headers and series selector. This is
+	// how per-tenant routing (e.g. Mimir's X-Scope-OrgID) is configured.
+	Endpoints []RemoteWriteEndpointConfig `json:"endpoints,omitempty"`
+}
+
+// RemoteWriteEndpointConfig describes one target of a multi-endpoint
+// remote_write fanout.
+type RemoteWriteEndpointConfig struct {
+	Name        string            `json:"name"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	BearerToken string            `json:"bearerToken,omitempty"`
+
+	// Match holds "label=value" selectors: a series is routed to this
+	// endpoint if it has at least one label matching one of these pairs.
+	// An empty Match list means the endpoint receives every series.
+	Match []string `json:"match,omitempty"`
+}
+
+// NewConfig creates a new Config instance with default values.
+func NewConfig() Config {
+	return Config{
+		URL:                 null.StringFrom("http://localhost:9090/api/v1/write"),
+		PushInterval:        types.NewNullDuration(5*time.Second, false),
+		ClientTimeout:       types.NewNullDuration(5*time.Second, false),
+		RemoteWriteProtocol: null.StringFrom(RemoteWriteProtocolV1),
+		Protocol:            null.StringFrom(ProtocolRemoteWrite),
+		MaxBufferBytes:      null.IntFrom(100 * 1024 * 1024),
+	}
+}
+
+// Apply merges the non-default values of cfg into a copy of c and returns it.
+func (c Config) Apply(cfg Config) Config {
+	if cfg.URL.Valid {
+		c.URL = cfg.URL
+	}
+	if cfg.InsecureSkipTLSVerify.Valid {
+		c.InsecureSkipTLSVerify = cfg.InsecureSkipTLSVerify
+	}
+	if cfg.Username.Valid {
+		c.Username = cfg.Username
+	}
+	if cfg.Password.Valid {
+		c.Password = cfg.Password
+	}
+	if cfg.BearerToken.Valid {
+		c.BearerToken = cfg.BearerToken
+	}
+	if len(cfg.Headers) > 0 {
+		c.Headers = cfg.Headers
+	}
+	if cfg.PushInterval.Valid {
+		c.PushInterval = cfg.PushInterval
+	}
+	if cfg.ClientTimeout.Valid {
+		c.ClientTimeout = cfg.ClientTimeout
+	}
+	if len(cfg.TrendStats) > 0 {
+		c.TrendStats = cfg.TrendStats
+	}
+	if cfg.TrendAsNativeHistogram.Valid {
+		c.TrendAsNativeHistogram = cfg.TrendAsNativeHistogram
+	}
+	if cfg.MaxSampleAge.Valid {
+		c.MaxSampleAge = cfg.MaxSampleAge
+	}
+	if cfg.BufferDir.Valid {
+		c.BufferDir = cfg.BufferDir
+	}
+	if cfg.MaxBufferBytes.Valid {
+		c.MaxBufferBytes = cfg.MaxBufferBytes
+	}
+	if cfg.RemoteWriteProtocol.Valid {
+		c.RemoteWriteProtocol = cfg.RemoteWriteProtocol
+	}
+	if cfg.Protocol.Valid {
+		c.Protocol = cfg.Protocol
+	}
+	if cfg.OTLPEndpoint.Valid {
+		c.OTLPEndpoint = cfg.OTLPEndpoint
+	}
+	if cfg.OTLPTransport.Valid {
+		c.OTLPTransport = cfg.OTLPTransport
+	}
+	if len(cfg.OTLPHeaders) > 0 {
+		c.OTLPHeaders = cfg.OTLPHeaders
+	}
+	if cfg.OTLPInsecure.Valid {
+		c.OTLPInsecure = cfg.OTLPInsecure
+	}
+	if len(cfg.Endpoints) > 0 {
+		c.Endpoints = cfg.Endpoints
+	}
+	return c
+}
+
+// GetConsolidatedConfig combines the options' sources and returns the merged Config.
+func GetConsolidatedConfig(jsonRawConf json.RawMessage, env map[string]string, confArg string) (Config, error) {
+	result := NewConfig()
+	if jsonRawConf != nil {
+		jsonConf, err := parseJSON(jsonRawConf)
+		if err != nil {
+			return result, fmt.Errorf("unable to parse JSON config: %w", err)
+		}
+		result = result.Apply(jsonConf)
+	}
+	if envConf, ok := parseEnv(env); ok {
+		result = result.Apply(envConf)
+	}
+	if confArg != "" {
+		argConf, err := parseArg(confArg)
+		if err != nil {
+			return result, fmt.Errorf("unable to parse the config argument: %w", err)
+		}
+		result = result.Apply(argConf)
+	}
+	return result, nil
+}
+
+func parseJSON(raw json.RawMessage) (Config, error) {
+	var conf Config
+	err := json.Unmarshal(raw, &conf)
+	return conf, err
+}
+
+// parseEnv builds a Config out of every field whose `envconfig` tag is set
+// in the given environment, taking the place of a real envconfig.Process
+// call so the accepted set of variables is driven by the same struct tags
+// documented on Config.
+func parseEnv(env map[string]string) (Config, bool) {
+	var conf Config
+	var found bool
+
+	v := reflect.ValueOf(&conf).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		envKey := t.Field(i).Tag.Get("envconfig")
+		if envKey == "" {
+			continue
+		}
+		raw, ok := env[envKey]
+		if !ok {
+			continue
+		}
+		if err := setConfigField(v.Field(i), raw); err == nil {
+			found = true
+		}
+	}
+	return conf, found
+}
+
+// parseArg parses the flat key=value,key2=value2 form accepted by other k6
+// outputs (e.g. `-o output-prometheus-remote=url=...`), matching keys
+// against Config's `json` tags.
+func parseArg(arg string) (Config, error) {
+	var conf Config
+
+	v := reflect.ValueOf(&conf).Elem()
+	t := v.Type()
+	fieldByKey := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		fieldByKey[key] = i
+	}
+
+	for _, pair := range strings.Split(arg, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return conf, fmt.Errorf("invalid config argument %q: expected key=value", pair)
+		}
+		key, value := kv[0], kv[1]
+		idx, ok := fieldByKey[key]
+		if !ok {
+			return conf, fmt.Errorf("unknown config argument key %q", key)
+		}
+		if err := setConfigField(v.Field(idx), value); err != nil {
+			return conf, fmt.Errorf("invalid value for %q: %w", key, err)
+		}
+	}
+	return conf, nil
+}
+
+// setConfigField assigns raw into a Config field reached via parseEnv or
+// parseArg, both of which only ever hand it a string. Map and slice-of-
+// struct fields (Headers, OTLPHeaders, Endpoints) aren't expressible in
+// this flat form and are rejected.
+func setConfigField(field reflect.Value, raw string) error {
+	switch fv := field.Addr().Interface().(type) {
+	case *null.String:
+		*fv = null.StringFrom(raw)
+	case *null.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q: %w", raw, err)
+		}
+		*fv = null.BoolFrom(b)
+	case *null.Int:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid int %q: %w", raw, err)
+		}
+		*fv = null.IntFrom(n)
+	case *types.NullDuration:
+		d, err := types.ParseExtendedDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", raw, err)
+		}
+		*fv = types.NullDurationFrom(d)
+	case *[]string:
+		*fv = strings.Split(raw, ";")
+	default:
+		return fmt.Errorf("field of type %T can't be set from a flat key=value pair", fv)
+	}
+	return nil
+}
+
+// RemoteConfig converts the Config into the remote.HTTPClientConfig
+// consumed by remote.NewWriteClient.
+func (c Config) RemoteConfig() (remote.HTTPClientConfig, error) {
+	cc := remote.HTTPClientConfig{
+		Timeout:               c.ClientTimeout.TimeDuration(),
+		InsecureSkipTLSVerify: c.InsecureSkipTLSVerify.Bool,
+		Headers:               c.Headers,
+	}
+	if c.Username.Valid || c.Password.Valid {
+		cc.BasicAuth = &remote.BasicAuth{
+			Username: c.Username.String,
+			Password: c.Password.String,
+		}
+	}
+	if c.BearerToken.Valid {
+		cc.BearerToken = c.BearerToken.String
+	}
+	return cc, nil
+}
+
+// protocol returns the configured remote write protocol, defaulting to prw1
+// when it hasn't been explicitly set.
+func (c Config) protocol() string {
+	if !c.RemoteWriteProtocol.Valid || c.RemoteWriteProtocol.String == "" {
+		return RemoteWriteProtocolV1
+	}
+	return c.RemoteWriteProtocol.String
+}
+
+// outputProtocol returns the configured output transport, defaulting to
+// remote_write when it hasn't been explicitly set.
+func (c Config) outputProtocol() string {
+	if !c.Protocol.Valid || c.Protocol.String == "" {
+		return ProtocolRemoteWrite
+	}
+	return c.Protocol.String
+}
@@ -0,0 +1,80 @@
+package remotewrite
+
+import (
+	"testing"
+)
+
+// TestParseEnv is a regression test for a chunk0-1 bug: parseEnv only ever
+// hand-checked two of the many envconfig-tagged fields, silently ignoring
+// the rest (including every field added by later requests).
+func TestParseEnv(t *testing.T) {
+	env := map[string]string{
+		"K6_PROMETHEUS_RW_SERVER_URL":                "http://example.com/write",
+		"K6_PROMETHEUS_RW_REMOTE_WRITE_PROTOCOL":     "prw2",
+		"K6_PROMETHEUS_RW_MAX_SAMPLE_AGE":            "30s",
+		"K6_PROMETHEUS_RW_MAX_BUFFER_BYTES":          "1024",
+		"K6_PROMETHEUS_RW_TREND_AS_NATIVE_HISTOGRAM": "true",
+		"K6_PROMETHEUS_RW_TREND_STATS":               "min;max;avg",
+	}
+
+	conf, found := parseEnv(env)
+	if !found {
+		t.Fatal("expected found=true")
+	}
+	if conf.URL.String != "http://example.com/write" {
+		t.Errorf("URL = %q, want %q", conf.URL.String, "http://example.com/write")
+	}
+	if conf.RemoteWriteProtocol.String != "prw2" {
+		t.Errorf("RemoteWriteProtocol = %q, want %q", conf.RemoteWriteProtocol.String, "prw2")
+	}
+	if conf.MaxSampleAge.TimeDuration().String() != "30s" {
+		t.Errorf("MaxSampleAge = %s, want 30s", conf.MaxSampleAge.TimeDuration())
+	}
+	if conf.MaxBufferBytes.Int64 != 1024 {
+		t.Errorf("MaxBufferBytes = %d, want 1024", conf.MaxBufferBytes.Int64)
+	}
+	if !conf.TrendAsNativeHistogram.Bool {
+		t.Error("TrendAsNativeHistogram = false, want true")
+	}
+	if len(conf.TrendStats) != 3 || conf.TrendStats[0] != "min" {
+		t.Errorf("TrendStats = %v, want [min max avg]", conf.TrendStats)
+	}
+}
+
+func TestParseEnvNoMatches(t *testing.T) {
+	_, found := parseEnv(map[string]string{"SOME_UNRELATED_VAR": "x"})
+	if found {
+		t.Fatal("expected found=false when no envconfig keys match")
+	}
+}
+
+// TestParseArg is a regression test for a chunk0-1 bug: parseArg always
+// returned an empty Config regardless of input, silently discarding any
+// `-o output-prometheus-remote=...` argument.
+func TestParseArg(t *testing.T) {
+	conf, err := parseArg("url=http://example.com/write,insecureSkipTLSVerify=true,maxBufferBytes=2048")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.URL.String != "http://example.com/write" {
+		t.Errorf("URL = %q, want %q", conf.URL.String, "http://example.com/write")
+	}
+	if !conf.InsecureSkipTLSVerify.Bool {
+		t.Error("InsecureSkipTLSVerify = false, want true")
+	}
+	if conf.MaxBufferBytes.Int64 != 2048 {
+		t.Errorf("MaxBufferBytes = %d, want 2048", conf.MaxBufferBytes.Int64)
+	}
+}
+
+func TestParseArgUnknownKey(t *testing.T) {
+	if _, err := parseArg("notAField=123"); err == nil {
+		t.Fatal("expected an error for an unknown config argument key")
+	}
+}
+
+func TestParseArgMalformedPair(t *testing.T) {
+	if _, err := parseArg("url"); err == nil {
+		t.Fatal("expected an error for a pair missing '='")
+	}
+}
@@ -0,0 +1,174 @@
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/grafana/xk6-output-prometheus-remote/pkg/remote"
+
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	prompb "go.buf.build/grpc/go/prometheus/prometheus"
+)
+
+// endpoint wraps a single remote write target so that Output.flush can fan
+// the same flush out to several of them (e.g. a long-term Mimir tenant
+// plus a short-term local Prometheus), each with its own auth, headers
+// and series selector.
+type endpoint struct {
+	name     string
+	client   *remote.WriteClient
+	matchers []labelMatcher
+
+	// retries counts this endpoint's failed send attempts (store/storeV2
+	// bump it whenever the underlying client call errors), surfaced on the
+	// endpoint's own error log line so a consistently-failing endpoint is
+	// distinguishable from a one-off blip.
+	retries uint64
+}
+
+// labelMatcher is a "label=value" series selector, as configured in
+// RemoteWriteEndpointConfig.Match.
+type labelMatcher struct {
+	name  string
+	value string
+}
+
+func parseMatcher(s string) (labelMatcher, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return labelMatcher{}, fmt.Errorf("invalid match selector %q, expected label=value", s)
+	}
+	return labelMatcher{name: name, value: value}, nil
+}
+
+// matches reports whether the series (identified by its labels) should be
+// routed to this endpoint: true if the endpoint has no matchers
+// configured (it receives everything), or if any matcher is satisfied.
+func (e *endpoint) matches(labels []*prompb.Label) bool {
+	if len(e.matchers) == 0 {
+		return true
+	}
+	for _, l := range labels {
+		for _, m := range e.matchers {
+			if l.Name == m.name && l.Value == m.value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buildEndpoints builds the set of remote write targets for this Output.
+// When config.Endpoints is empty, it falls back to a single endpoint built
+// from the top-level URL/auth/headers options, preserving the previous
+// single-target behavior.
+func buildEndpoints(config Config) ([]*endpoint, error) {
+	if len(config.Endpoints) == 0 {
+		clientConfig, err := config.RemoteConfig()
+		if err != nil {
+			return nil, err
+		}
+		wc, err := remote.NewWriteClient(config.URL.String, clientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize the Prometheus remote write client: %w", err)
+		}
+		return []*endpoint{{name: "default", client: wc}}, nil
+	}
+
+	endpoints := make([]*endpoint, 0, len(config.Endpoints))
+	for _, ec := range config.Endpoints {
+		cc := remote.HTTPClientConfig{
+			Timeout:     config.ClientTimeout.TimeDuration(),
+			Headers:     ec.Headers,
+			BearerToken: ec.BearerToken,
+		}
+		if ec.Username != "" || ec.Password != "" {
+			cc.BasicAuth = &remote.BasicAuth{Username: ec.Username, Password: ec.Password}
+		}
+
+		wc, err := remote.NewWriteClient(ec.URL, cc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize the remote write client for endpoint %q: %w", ec.Name, err)
+		}
+
+		matchers := make([]labelMatcher, 0, len(ec.Match))
+		for _, s := range ec.Match {
+			m, err := parseMatcher(s)
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %q: %w", ec.Name, err)
+			}
+			matchers = append(matchers, m)
+		}
+
+		endpoints = append(endpoints, &endpoint{name: ec.Name, client: wc, matchers: matchers})
+	}
+	return endpoints, nil
+}
+
+// store sends the given series to this endpoint, filtering out any that
+// don't match its selector, and tracks the endpoint's own retry counter.
+func (e *endpoint) store(ctx context.Context, series []*prompb.TimeSeries) error {
+	filtered := series
+	if len(e.matchers) > 0 {
+		filtered = make([]*prompb.TimeSeries, 0, len(series))
+		for _, ts := range series {
+			if e.matches(ts.Labels) {
+				filtered = append(filtered, ts)
+			}
+		}
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+
+	err := e.client.Store(ctx, filtered)
+	if err != nil {
+		atomic.AddUint64(&e.retries, 1)
+	}
+	return err
+}
+
+// matchesRW2 is matches for a Remote-Write 2.0 series: its labels aren't
+// inlined as prompb.Label pairs but referenced indirectly through the
+// request's interned symbol table, so the lookup has to go through symbols
+// instead.
+func (e *endpoint) matchesRW2(labelRefs []uint32, symbols []string) bool {
+	if len(e.matchers) == 0 {
+		return true
+	}
+	for i := 0; i+1 < len(labelRefs); i += 2 {
+		name, value := symbols[labelRefs[i]], symbols[labelRefs[i+1]]
+		for _, m := range e.matchers {
+			if name == m.name && value == m.value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// storeV2 sends the given Remote-Write 2.0 request to this endpoint,
+// filtering out any series that don't match its selector the same way
+// store does for the 1.0 path, and tracks the endpoint's own retry counter.
+func (e *endpoint) storeV2(ctx context.Context, req *writev2.Request) error {
+	filtered := req.Timeseries
+	if len(e.matchers) > 0 {
+		filtered = make([]writev2.TimeSeries, 0, len(req.Timeseries))
+		for _, ts := range req.Timeseries {
+			if e.matchesRW2(ts.LabelsRefs, req.Symbols) {
+				filtered = append(filtered, ts)
+			}
+		}
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+
+	err := e.client.StoreV2(ctx, &writev2.Request{Symbols: req.Symbols, Timeseries: filtered})
+	if err != nil {
+		atomic.AddUint64(&e.retries, 1)
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package remotewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/xk6-output-prometheus-remote/pkg/remote"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	prompb "go.buf.build/grpc/go/prometheus/prometheus"
+)
+
+func TestParseMatcher(t *testing.T) {
+	m, err := parseMatcher("tenant=acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.name != "tenant" || m.value != "acme" {
+		t.Fatalf("got %+v, want {tenant acme}", m)
+	}
+
+	if _, err := parseMatcher("no-equals-sign"); err == nil {
+		t.Fatal("expected an error for a selector without '='")
+	}
+}
+
+func TestEndpointMatches(t *testing.T) {
+	noMatchers := &endpoint{}
+	if !noMatchers.matches([]*prompb.Label{{Name: "tenant", Value: "acme"}}) {
+		t.Error("an endpoint with no matchers should receive every series")
+	}
+
+	ep := &endpoint{matchers: []labelMatcher{{name: "tenant", value: "acme"}}}
+	if !ep.matches([]*prompb.Label{{Name: "tenant", Value: "acme"}}) {
+		t.Error("expected a matching label to route the series")
+	}
+	if ep.matches([]*prompb.Label{{Name: "tenant", Value: "other"}}) {
+		t.Error("expected a non-matching label to not route the series")
+	}
+	if ep.matches(nil) {
+		t.Error("expected no labels to not route the series")
+	}
+}
+
+// TestEndpointStoreFiltersNonMatchingSeries covers the per-endpoint
+// selector behavior from chunk0-3: a series that doesn't match any of the
+// endpoint's matchers must never reach its client.
+func TestEndpointStoreFiltersNonMatchingSeries(t *testing.T) {
+	var gotSeries int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSeries++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client, err := remote.NewWriteClient(srv.URL, remote.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build the client: %v", err)
+	}
+
+	ep := &endpoint{name: "tenant-a", client: client, matchers: []labelMatcher{{name: "tenant", value: "a"}}}
+
+	series := []*prompb.TimeSeries{
+		{Labels: []*prompb.Label{{Name: "tenant", Value: "b"}}},
+	}
+	if err := ep.store(context.Background(), series); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotSeries != 0 {
+		t.Fatal("expected the non-matching series to never reach the endpoint's client")
+	}
+
+	series = append(series, &prompb.TimeSeries{Labels: []*prompb.Label{{Name: "tenant", Value: "a"}}})
+	if err := ep.store(context.Background(), series); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotSeries != 1 {
+		t.Fatalf("expected exactly one request once a matching series is included, got %d", gotSeries)
+	}
+}
+
+func TestEndpointMatchesRW2(t *testing.T) {
+	// symbols[1]/symbols[2] is the "tenant"/"acme" pair; LabelsRefs walks
+	// the symbol table two entries at a time (name ref, value ref).
+	symbols := []string{"", "tenant", "acme", "other"}
+
+	noMatchers := &endpoint{}
+	if !noMatchers.matchesRW2([]uint32{1, 2}, symbols) {
+		t.Error("an endpoint with no matchers should receive every series")
+	}
+
+	ep := &endpoint{matchers: []labelMatcher{{name: "tenant", value: "acme"}}}
+	if !ep.matchesRW2([]uint32{1, 2}, symbols) {
+		t.Error("expected a matching label to route the series")
+	}
+	if ep.matchesRW2([]uint32{1, 3}, symbols) {
+		t.Error("expected a non-matching label to not route the series")
+	}
+	if ep.matchesRW2(nil, symbols) {
+		t.Error("expected no labels to not route the series")
+	}
+}
+
+// TestEndpointStoreV2FiltersNonMatchingSeries is a regression test for a
+// chunk0-1 bug: the Remote-Write 2.0 send path bypassed endpoint.matches
+// entirely and delivered every series to every endpoint, defeating the
+// chunk0-3 per-tenant fanout whenever RemoteWriteProtocol was prw2.
+func TestEndpointStoreV2FiltersNonMatchingSeries(t *testing.T) {
+	var gotSeries int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSeries++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client, err := remote.NewWriteClient(srv.URL, remote.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build the client: %v", err)
+	}
+
+	ep := &endpoint{name: "tenant-a", client: client, matchers: []labelMatcher{{name: "tenant", value: "a"}}}
+
+	symbols := []string{"", "tenant", "a", "b"}
+	req := &writev2.Request{
+		Symbols: symbols,
+		Timeseries: []writev2.TimeSeries{
+			{LabelsRefs: []uint32{1, 3}},
+		},
+	}
+	if err := ep.storeV2(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotSeries != 0 {
+		t.Fatal("expected the non-matching series to never reach the endpoint's client")
+	}
+
+	req.Timeseries = append(req.Timeseries, writev2.TimeSeries{LabelsRefs: []uint32{1, 2}})
+	if err := ep.storeV2(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotSeries != 1 {
+		t.Fatalf("expected exactly one request once a matching series is included, got %d", gotSeries)
+	}
+}
+
+// TestEndpointStoreCountsRetriesOnFailure checks that store bumps
+// endpoint.retries once per failed send, which is the count sendV1To and
+// sendV2 attach to the endpoint's error log line.
+func TestEndpointStoreCountsRetriesOnFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	client, err := remote.NewWriteClient(srv.URL, remote.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build the client: %v", err)
+	}
+
+	ep := &endpoint{name: "flaky", client: client}
+	series := []*prompb.TimeSeries{{Labels: []*prompb.Label{{Name: "__name__", Value: "test"}}}}
+
+	if err := ep.store(context.Background(), series); err == nil {
+		t.Fatal("expected an error from the failing endpoint")
+	}
+	if ep.retries != 1 {
+		t.Fatalf("expected retries to be 1 after one failed send, got %d", ep.retries)
+	}
+
+	if err := ep.store(context.Background(), series); err == nil {
+		t.Fatal("expected an error from the failing endpoint")
+	}
+	if ep.retries != 2 {
+		t.Fatalf("expected retries to be 2 after two failed sends, got %d", ep.retries)
+	}
+}
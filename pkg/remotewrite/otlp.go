@@ -0,0 +1,191 @@
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"go.k6.io/k6/metrics"
+
+	"github.com/grafana/xk6-output-prometheus-remote/pkg/otlpwrite"
+	prompb "go.buf.build/grpc/go/prometheus/prometheus"
+)
+
+// exponentialHistogram is implemented by the native histogram sink so that
+// flushOTLP can reuse its bucket boundaries instead of recomputing them,
+// keeping the OTLP exponential buckets consistent with the Prometheus
+// native histogram output.
+type exponentialHistogram interface {
+	ExponentialBuckets() (scale int32, zeroCount uint64, positiveOffset int32, positiveBuckets []uint64, sum float64, count uint64)
+}
+
+// flushOTLP converts the series flagged for delivery in this flush into an
+// OTLP metrics payload and exports it, as an alternative to the Prometheus
+// Remote-Write path.
+//
+// It deliberately doesn't assert on the sink's concrete type: only
+// PrompbMapSinker is guaranteed (any RegisterSinkFactory override can swap
+// it out), so values are read back from the same MapPrompb() output the
+// Remote-Write path already trusts, instead of duplicating per-type field
+// access here.
+func (o *Output) flushOTLP(seen map[metrics.TimeSeries]struct{}) error {
+	return o.otlpClient.Export(context.Background(), o.buildOTLPMetrics(seen))
+}
+
+// buildOTLPMetrics does the actual series-to-OTLP conversion, split out
+// from flushOTLP so it can be exercised without a live OTLP endpoint.
+func (o *Output) buildOTLPMetrics(seen map[metrics.TimeSeries]struct{}) pmetric.Metrics {
+	b := otlpwrite.NewBuilder(o.otlpResourceAttrs(seen))
+
+	for s := range seen {
+		swm := o.tsdb[s]
+
+		if eh, ok := swm.Measure.(exponentialHistogram); ok {
+			attrs := labelsToAttrs(MapSeries(swm.TimeSeries, ""))
+			scale, zeroCount, offset, buckets, sum, count := eh.ExponentialBuckets()
+			b.AddExponentialHistogram(swm.Metric.Name, scale, zeroCount, offset, buckets, sum, count, swm.Latest, attrs)
+			continue
+		}
+
+		if swm.Metric.Type == metrics.Trend {
+			addTrendSummary(b, swm)
+			continue
+		}
+
+		for _, ts := range swm.MapPrompb() {
+			attrs := labelsToAttrs(ts.Labels)
+			name := labelValue(ts.Labels, "__name__")
+			if name == "" {
+				name = swm.Metric.Name
+			}
+			var value float64
+			if len(ts.Samples) > 0 {
+				value = ts.Samples[0].Value
+			}
+
+			if swm.Metric.Type == metrics.Counter {
+				b.AddSum(name, value, swm.Latest, attrs)
+			} else {
+				// Gauge and Rate map onto a plain OTLP Gauge point; Trend is
+				// handled separately above via addTrendSummary.
+				b.AddGauge(name, value, swm.Latest, attrs)
+			}
+		}
+	}
+
+	return b.Build()
+}
+
+// addTrendSummary converts the extended-stats series for a single
+// (non-native-histogram) Trend metric into one OTLP Summary point built
+// from its `_sum`/`_count` series and its `quantile`-labeled series,
+// matching the `<name>_sum`/`<name>_count`/`<name>{quantile="q"}` layout
+// MapPrompb already produces for Remote-Write. Any other per-stat series
+// (e.g. avg/min/max/med) doesn't fit the Summary data model, so it still
+// falls back to a plain Gauge, same as before this split.
+func addTrendSummary(b *otlpwrite.Builder, swm *seriesWithMeasure) {
+	var (
+		sum, count         float64
+		haveSum, haveCount bool
+		quantiles          = make(map[float64]float64)
+		extras             []*prompb.TimeSeries
+	)
+
+	for _, ts := range swm.MapPrompb() {
+		name := labelValue(ts.Labels, "__name__")
+		var value float64
+		if len(ts.Samples) > 0 {
+			value = ts.Samples[0].Value
+		}
+
+		switch {
+		case labelValue(ts.Labels, "quantile") != "":
+			if q, err := strconv.ParseFloat(labelValue(ts.Labels, "quantile"), 64); err == nil {
+				quantiles[q] = value
+			}
+		case strings.HasSuffix(name, "_sum"):
+			sum, haveSum = value, true
+		case strings.HasSuffix(name, "_count"):
+			count, haveCount = value, true
+		default:
+			extras = append(extras, ts)
+		}
+	}
+
+	if haveSum && haveCount {
+		b.AddSummary(swm.Metric.Name, sum, uint64(count), quantiles, swm.Latest, labelsToAttrs(MapSeries(swm.TimeSeries, "")))
+	}
+	for _, ts := range extras {
+		name := labelValue(ts.Labels, "__name__")
+		if name == "" {
+			name = swm.Metric.Name
+		}
+		var value float64
+		if len(ts.Samples) > 0 {
+			value = ts.Samples[0].Value
+		}
+		b.AddGauge(name, value, swm.Latest, labelsToAttrs(ts.Labels))
+	}
+}
+
+// otlpResourceAttrs builds the Resource attributes stamped on every OTLP
+// export: the fixed service identity plus whichever k6 tags are the same
+// across every series in this flush (e.g. tags set with `k6 run --tag`),
+// since those describe the run as a whole rather than one series and
+// belong on the Resource instead of being repeated on every data point.
+func (o *Output) otlpResourceAttrs(seen map[metrics.TimeSeries]struct{}) map[string]string {
+	attrs := map[string]string{
+		"service.name":   "k6",
+		"k6.test.run_id": fmt.Sprintf("%d", o.testStartTime.UnixNano()),
+	}
+	for k, v := range commonLabels(seen, o.tsdb) {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// commonLabels returns the labels shared, with the same value, by every
+// series in seen.
+func commonLabels(seen map[metrics.TimeSeries]struct{}, tsdb map[metrics.TimeSeries]*seriesWithMeasure) map[string]string {
+	common := make(map[string]string)
+	first := true
+	for s := range seen {
+		labels := labelsToAttrs(MapSeries(tsdb[s].TimeSeries, ""))
+		if first {
+			for k, v := range labels {
+				common[k] = v
+			}
+			first = false
+			continue
+		}
+		for k, v := range common {
+			if labels[k] != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
+
+func labelsToAttrs(labels []*prompb.Label) map[string]string {
+	attrs := make(map[string]string, len(labels))
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			continue
+		}
+		attrs[l.Name] = l.Value
+	}
+	return attrs
+}
+
+func labelValue(labels []*prompb.Label, name string) string {
+	for _, l := range labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package remotewrite
+
+import (
+	"testing"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+// newTestSWM registers a metric of the given type and returns a
+// seriesWithMeasure for it with one sample already recorded, so tests can
+// build a tsdb/seen pair without going through aggregateSamples.
+func newTestSWM(t *testing.T, mtype metrics.MetricType, name string) (*seriesWithMeasure, metrics.TimeSeries) {
+	t.Helper()
+
+	registry := metrics.NewRegistry()
+	m, err := registry.NewMetric(name, mtype)
+	if err != nil {
+		t.Fatalf("failed to register metric %q: %v", name, err)
+	}
+
+	series := metrics.TimeSeries{Metric: m, Tags: registry.RootTagSet()}
+	swm := newSeriesWithMeasure(series, false, nil)
+	swm.Latest = time.Now()
+	swm.Measure.Add(metrics.Sample{TimeSeries: series, Time: swm.Latest, Value: 1})
+	return swm, series
+}
+
+// TestFlushOTLPDoesNotPanic exercises buildOTLPMetrics against one series
+// of every metric type this output knows how to emit. It's a regression
+// test for a chunk0-6 bug: RegisterSinkFactory swapped the dynamic type
+// stored in seriesWithMeasure.Measure for Counter/Gauge/Rate, and
+// flushOTLP still asserted on the old concrete *metrics.CounterSink et al.,
+// which panicked on every OTLP flush.
+func TestFlushOTLPDoesNotPanic(t *testing.T) {
+	o := &Output{
+		tsdb:          make(map[metrics.TimeSeries]*seriesWithMeasure),
+		testStartTime: time.Now(),
+	}
+
+	seen := make(map[metrics.TimeSeries]struct{})
+	for i, mtype := range []metrics.MetricType{metrics.Counter, metrics.Gauge, metrics.Rate, metrics.Trend} {
+		swm, series := newTestSWM(t, mtype, mtype.String())
+		o.tsdb[series] = swm
+		seen[series] = struct{}{}
+		_ = i
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("buildOTLPMetrics panicked: %v", r)
+		}
+	}()
+
+	got := o.buildOTLPMetrics(seen)
+	if got.ResourceMetrics().Len() != 1 {
+		t.Fatalf("expected a single ResourceMetrics, got %d", got.ResourceMetrics().Len())
+	}
+	if got.MetricCount() == 0 {
+		t.Fatal("expected at least one OTLP metric to be built")
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/grafana/xk6-output-prometheus-remote/pkg/otlpwrite"
 	"github.com/grafana/xk6-output-prometheus-remote/pkg/remote"
 
 	"go.k6.io/k6/metrics"
 	"go.k6.io/k6/output"
 
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
 	"github.com/sirupsen/logrus"
 	prompb "go.buf.build/grpc/go/prometheus/prometheus"
 )
@@ -26,8 +30,31 @@ type Output struct {
 	tsdb               map[metrics.TimeSeries]*seriesWithMeasure
 	trendStatsResolver map[string]func(*metrics.TrendSink) float64
 
-	// TODO: copy the prometheus/remote.WriteClient interface and depend on it
-	client *remote.WriteClient
+	// testStartTime is stamped on Start and used as the CreatedTimestamp
+	// for Remote-Write 2.0 series, so downstream Prometheus/Mimir can
+	// reset counters correctly across test runs.
+	testStartTime time.Time
+
+	// droppedOldSamples and droppedSeries accumulate the counts behind
+	// k6_prw_dropped_old_samples_total and k6_prw_dropped_series_total,
+	// bumped whenever MaxSampleAge causes flush to drop a stale series.
+	droppedOldSamples uint64
+	droppedSeries     uint64
+
+	// endpoints holds one or more remote write targets. It has a single
+	// entry built from the top-level URL/auth/headers options unless
+	// config.Endpoints configures an explicit multi-target fanout.
+	endpoints []*endpoint
+
+	// otlpClient is set instead of endpoints when config.Protocol is "otlp".
+	otlpClient *otlpwrite.Client
+
+	// wal, when config.BufferDir is set, durably queues flushes on disk
+	// instead of sending them inline; drainWAL delivers them in the
+	// background. Only used for the prw1 path.
+	wal       *wal
+	walStopCh chan struct{}
+	walWG     sync.WaitGroup
 }
 
 func New(params output.Params) (*Output, error) {
@@ -38,23 +65,50 @@ func New(params output.Params) (*Output, error) {
 		return nil, err
 	}
 
-	clientConfig, err := config.RemoteConfig()
-	if err != nil {
-		return nil, err
-	}
-
-	wc, err := remote.NewWriteClient(config.URL.String, clientConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize the Prometheus remote write client: %w", err)
-	}
-
 	o := &Output{
-		client: wc,
 		config: config,
 		logger: logger,
 		tsdb:   make(map[metrics.TimeSeries]*seriesWithMeasure),
 	}
 
+	switch config.outputProtocol() {
+	case ProtocolOTLP:
+		oc, err := otlpwrite.NewClient(otlpwrite.ClientConfig{
+			Endpoint:  config.OTLPEndpoint.String,
+			Transport: otlpwrite.Transport(config.OTLPTransport.String),
+			Headers:   config.OTLPHeaders,
+			Timeout:   config.ClientTimeout.TimeDuration(),
+			Insecure:  config.OTLPInsecure.Bool,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize the OTLP client: %w", err)
+		}
+		o.otlpClient = oc
+	default:
+		endpoints, err := buildEndpoints(config)
+		if err != nil {
+			return nil, err
+		}
+		o.endpoints = endpoints
+
+		if config.BufferDir.Valid && config.BufferDir.String != "" {
+			w, err := openWAL(config.BufferDir.String, config.MaxBufferBytes.Int64, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize the buffer directory: %w", err)
+			}
+			o.wal = w
+			o.walStopCh = make(chan struct{})
+
+			if config.protocol() == RemoteWriteProtocolV2 {
+				// The durable buffer only covers the prw1 path for now
+				// (see flush); warn instead of silently dropping the
+				// durability guarantee bufferDir promises.
+				logger.Warn("bufferDir has no effect with RemoteWriteProtocol set to prw2: " +
+					"Remote-Write 2.0 flushes are sent inline and are not buffered or retried")
+			}
+		}
+	}
+
 	if len(config.TrendStats) > 0 {
 		if err := o.setTrendStatsResolver(config.TrendStats); err != nil {
 			return nil, err
@@ -64,10 +118,20 @@ func New(params output.Params) (*Output, error) {
 }
 
 func (o *Output) Description() string {
+	if o.config.outputProtocol() == ProtocolOTLP {
+		return fmt.Sprintf("OTLP metrics (%s)", o.config.OTLPEndpoint.String)
+	}
 	return fmt.Sprintf("Prometheus remote write (%s)", o.config.URL.String)
 }
 
 func (o *Output) Start() error {
+	o.testStartTime = time.Now()
+
+	if o.wal != nil {
+		o.walWG.Add(1)
+		go o.drainWAL(o.walStopCh)
+	}
+
 	d := o.config.PushInterval.TimeDuration()
 	periodicFlusher, err := output.NewPeriodicFlusher(d, o.flush)
 	if err != nil {
@@ -81,6 +145,10 @@ func (o *Output) Start() error {
 func (o *Output) Stop() error {
 	o.logger.Debug("Stopping the output")
 	o.periodicFlusher.Stop()
+	if o.wal != nil {
+		close(o.walStopCh)
+		o.walWG.Wait()
+	}
 	o.logger.Debug("Output stopped")
 	return nil
 }
@@ -138,13 +206,18 @@ func (o *Output) flush() {
 	defer func() {
 		d := time.Since(start)
 		okmsg := "Successful flushed time series to remote write endpoint"
+		fields := logrus.Fields{
+			"nts":                              nts,
+			"k6_prw_dropped_old_samples_total": atomic.LoadUint64(&o.droppedOldSamples),
+			"k6_prw_dropped_series_total":      atomic.LoadUint64(&o.droppedSeries),
+		}
 		if d > time.Duration(o.config.PushInterval.Duration) {
 			// There is no intermediary storage so warn if writing to remote write endpoint becomes too slow
-			o.logger.WithField("nts", nts).
+			o.logger.WithFields(fields).
 				Warnf("%s but it took %s while flush period is %s. Some samples may be dropped.",
 					okmsg, d.String(), o.config.PushInterval.String())
 		} else {
-			o.logger.WithField("nts", nts).WithField("took", d).Debug(okmsg)
+			o.logger.WithFields(fields).WithField("took", d).Debug(okmsg)
 		}
 	}()
 
@@ -161,17 +234,111 @@ func (o *Output) flush() {
 	// c) not have duplicate timestamps within 1 timeseries, see https://github.com/prometheus/prometheus/issues/9210
 	// Prometheus write handler processes only some fields as of now, so here we'll add only them.
 
-	promTimeSeries := o.convertToPbSeries(samplesContainers)
-	nts = len(promTimeSeries)
-	o.logger.WithField("nts", nts).Debug("Converted samples to Prometheus TimeSeries")
+	seen := o.aggregateSamples(samplesContainers)
+	o.dropStaleSeries(seen)
+	nts = len(seen)
+
+	if o.config.outputProtocol() == ProtocolOTLP {
+		if err := o.flushOTLP(seen); err != nil {
+			o.logger.WithError(err).Error("Failed to export the metrics to the OTLP endpoint")
+		}
+		return
+	}
+
+	// TODO: the durable buffer only covers the prw1 path for now.
+	if o.wal != nil && o.config.protocol() != RemoteWriteProtocolV2 {
+		o.enqueueWAL(seen)
+		return
+	}
 
-	if err := o.client.Store(context.Background(), promTimeSeries); err != nil {
-		o.logger.WithError(err).Error("Failed to send the time series data to the endpoint")
+	if o.config.protocol() == RemoteWriteProtocolV2 {
+		req := o.convertToPbSeriesV2(seen, o.testStartTime)
+		o.logger.WithField("nts", nts).Debug("Converted samples to Remote-Write 2.0 TimeSeries")
+		o.sendV2(o.endpoints, req, seen)
 		return
 	}
+
+	o.sendV1(seen)
+}
+
+// sendV1 fans the flushed series out to every configured endpoint in
+// parallel, each with independent error handling and its own retry
+// counter, so a slow or failing endpoint doesn't hold up the others.
+func (o *Output) sendV1(seen map[metrics.TimeSeries]struct{}) {
+	o.sendV1To(o.endpoints, seen)
+}
+
+// sendV1To is sendV1 restricted to a subset of endpoints. It's used by the
+// Remote-Write 2.0 fallback path, which must only resend to the endpoints
+// that actually rejected the v2 request, not the ones that already
+// accepted it.
+func (o *Output) sendV1To(endpoints []*endpoint, seen map[metrics.TimeSeries]struct{}) {
+	promTimeSeries := o.convertSeenToPbSeries(seen)
+
+	var wg sync.WaitGroup
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func(ep *endpoint) {
+			defer wg.Done()
+			if err := ep.store(context.Background(), promTimeSeries); err != nil {
+				o.logger.WithField("endpoint", ep.name).WithField("retries", atomic.LoadUint64(&ep.retries)).WithError(err).
+					Error("Failed to send the time series data to the endpoint")
+			}
+		}(ep)
+	}
+	wg.Wait()
+}
+
+// sendV2 fans the given Remote-Write 2.0 request out to every endpoint in
+// parallel, the same way sendV1To does for the 1.0 path, so each endpoint's
+// series matchers apply to the v2 request too and a slow or failing
+// endpoint doesn't hold up the others.
+func (o *Output) sendV2(endpoints []*endpoint, req *writev2.Request, seen map[metrics.TimeSeries]struct{}) {
+	var (
+		mu       sync.Mutex
+		fellBack []*endpoint
+		wg       sync.WaitGroup
+	)
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func(ep *endpoint) {
+			defer wg.Done()
+			if err := ep.storeV2(context.Background(), req); err != nil {
+				if remote.UnsupportedMediaType(err) {
+					o.logger.WithField("endpoint", ep.name).
+						Warn("Remote write endpoint doesn't support Remote-Write 2.0, falling back to 1.0")
+					mu.Lock()
+					fellBack = append(fellBack, ep)
+					mu.Unlock()
+					return
+				}
+				o.logger.WithField("endpoint", ep.name).WithField("retries", atomic.LoadUint64(&ep.retries)).WithError(err).
+					Error("Failed to send the time series data to the endpoint")
+			}
+		}(ep)
+	}
+	wg.Wait()
+
+	if len(fellBack) > 0 {
+		// Only the endpoints that actually rejected v2 get the v1
+		// retry: the others already accepted the v2 request, and
+		// resending would duplicate their samples.
+		o.sendV1To(fellBack, seen)
+	}
+}
+
+func (o *Output) convertSeenToPbSeries(seen map[metrics.TimeSeries]struct{}) []*prompb.TimeSeries {
+	pbseries := make([]*prompb.TimeSeries, 0, len(seen))
+	for s := range seen {
+		pbseries = append(pbseries, o.tsdb[s].MapPrompb()...)
+	}
+	return pbseries
 }
 
-func (o *Output) convertToPbSeries(samplesContainers []metrics.SampleContainer) []*prompb.TimeSeries {
+// aggregateSamples buckets the buffered samples into the Output's tsdb,
+// aggregating repeated samples for the same time series, and returns the
+// set of series that received a new value during this flush.
+func (o *Output) aggregateSamples(samplesContainers []metrics.SampleContainer) map[metrics.TimeSeries]struct{} {
 	// The seen map is required because the samples containers
 	// could have several samples for the same time series
 	//  in this way, we can aggregate and flush them in a unique value
@@ -228,11 +395,7 @@ func (o *Output) convertToPbSeries(samplesContainers []metrics.SampleContainer)
 		}
 	}
 
-	pbseries := make([]*prompb.TimeSeries, 0, len(seen))
-	for s := range seen {
-		pbseries = append(pbseries, o.tsdb[s].MapPrompb()...)
-	}
-	return pbseries
+	return seen
 }
 
 type seriesWithMeasure struct {
@@ -251,66 +414,26 @@ type seriesWithMeasure struct {
 
 // TODO: unit test this
 func (swm seriesWithMeasure) MapPrompb() []*prompb.TimeSeries {
-	var newts []*prompb.TimeSeries
-
-	mapMonoSeries := func(s metrics.TimeSeries, t time.Time) prompb.TimeSeries {
-		return prompb.TimeSeries{
-			// TODO: should we add the suffix for
-			// Counter, Rate and Gauge?
-			Labels: MapSeries(s, ""),
-			Samples: []*prompb.Sample{
-				{Timestamp: t.UnixMilli()},
-			},
-		}
-	}
-
-	switch swm.Metric.Type {
-	case metrics.Counter:
-		ts := mapMonoSeries(swm.TimeSeries, swm.Latest)
-		ts.Samples[0].Value = swm.Measure.(*metrics.CounterSink).Value
-		newts = []*prompb.TimeSeries{&ts}
-
-	case metrics.Gauge:
-		ts := mapMonoSeries(swm.TimeSeries, swm.Latest)
-		ts.Samples[0].Value = swm.Measure.(*metrics.GaugeSink).Value
-		newts = []*prompb.TimeSeries{&ts}
-
-	case metrics.Rate:
-		ts := mapMonoSeries(swm.TimeSeries, swm.Latest)
-		// pass zero duration here because time is useless for formatting rate
-		rateVals := swm.Measure.(*metrics.RateSink).Format(time.Duration(0))
-		ts.Samples[0].Value = rateVals["rate"]
-		newts = []*prompb.TimeSeries{&ts}
-
-	case metrics.Trend:
-		// TODO:
-		//	- Add a PrompbMapSinker interface
-		//    and implements it on all the sinks "extending" them.
-		//  - Call directly MapPrompb on Measure without any type assertion.
-		trend, ok := swm.Measure.(prompbMapper)
-		if !ok {
-			panic("Measure for Trend types must implement MapPromPb")
-		}
-		newts = trend.MapPrompb(swm.TimeSeries, swm.Latest)
-	default:
-		panic(fmt.Sprintf("Something is really off, as I cannot recognize the type of metric %s: `%s`", swm.Metric.Name, swm.Metric.Type))
+	sinker, ok := swm.Measure.(PrompbMapSinker)
+	if !ok {
+		panic(fmt.Sprintf("Measure for metric %q (%s) must implement PrompbMapSinker", swm.Metric.Name, swm.Metric.Type))
 	}
-	return newts
-}
-
-type prompbMapper interface {
-	MapPrompb(series metrics.TimeSeries, t time.Time) []*prompb.TimeSeries
+	return sinker.MapPrompb(swm.TimeSeries, swm.Latest)
 }
 
+// newSeriesWithMeasure builds the sink for a newly-seen time series. It
+// looks the metric type up in sinkFactories first, so a RegisterSinkFactory
+// call can override the built-in Counter/Gauge/Rate/Trend sinks; only
+// Trend falls back to the native-histogram-vs-extended-stats choice below
+// when nothing has been registered for it.
 func newSeriesWithMeasure(series metrics.TimeSeries, trendAsNativeHistogram bool, tsr TrendStatsResolver) *seriesWithMeasure {
+	if factory, ok := sinkFactories[series.Metric.Type]; ok {
+		return &seriesWithMeasure{TimeSeries: series, Measure: factory(series.Metric)}
+	}
+
 	var sink metrics.Sink
 	switch series.Metric.Type {
-	case metrics.Counter:
-		sink = &metrics.CounterSink{}
-	case metrics.Gauge:
-		sink = &metrics.GaugeSink{}
 	case metrics.Trend:
-		// TODO: refactor encapsulating in a factory method
 		if trendAsNativeHistogram {
 			sink = newNativeHistogramSink(series.Metric)
 		} else {
@@ -321,8 +444,6 @@ func newSeriesWithMeasure(series metrics.TimeSeries, trendAsNativeHistogram bool
 				panic(err)
 			}
 		}
-	case metrics.Rate:
-		sink = &metrics.RateSink{}
 	default:
 		panic(fmt.Sprintf("metric type %q unsupported", series.Metric.Type.String()))
 	}
@@ -0,0 +1,102 @@
+package remotewrite
+
+import (
+	"time"
+
+	"go.k6.io/k6/metrics"
+
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+// symbolTable interns label names/values into a single per-request []string,
+// as required by the Remote-Write 2.0 wire format. Symbol 0 is reserved for
+// the empty string, matching the convention used by Prometheus itself.
+type symbolTable struct {
+	symbols []string
+	index   map[string]uint32
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{
+		symbols: []string{""},
+		index:   map[string]uint32{"": 0},
+	}
+}
+
+func (t *symbolTable) ref(s string) uint32 {
+	if ref, ok := t.index[s]; ok {
+		return ref
+	}
+	ref := uint32(len(t.symbols))
+	t.symbols = append(t.symbols, s)
+	t.index[s] = ref
+	return ref
+}
+
+// convertToPbSeriesV2 builds a Remote-Write 2.0 request out of the series
+// that were flagged for delivery in this flush, interning all label names
+// and values into a single symbol table shared by the whole request.
+//
+// A metric can map to more than one prompb.TimeSeries (every Trend stat,
+// or every native-histogram bucket series, comes back as its own entry
+// from MapPrompb), each with its own labels, so each one becomes its own
+// writev2.TimeSeries rather than being flattened into a single series
+// under the base labels.
+func (o *Output) convertToPbSeriesV2(seen map[metrics.TimeSeries]struct{}, testStart time.Time) *writev2.Request {
+	symbols := newSymbolTable()
+	req := &writev2.Request{}
+
+	for s := range seen {
+		swm := o.tsdb[s]
+		metadata := metadataFor(swm, symbols)
+
+		for _, sample := range swm.MapPrompb() {
+			labelRefs := make([]uint32, 0, len(sample.Labels)*2)
+			for _, label := range sample.Labels {
+				labelRefs = append(labelRefs, symbols.ref(label.Name), symbols.ref(label.Value))
+			}
+
+			ts := writev2.TimeSeries{
+				LabelsRefs:       labelRefs,
+				CreatedTimestamp: testStart.UnixMilli(),
+				Metadata:         metadata,
+			}
+			for _, s := range sample.Samples {
+				ts.Samples = append(ts.Samples, writev2.Sample{Value: s.Value, Timestamp: s.Timestamp})
+			}
+			req.Timeseries = append(req.Timeseries, ts)
+		}
+	}
+
+	req.Symbols = symbols.symbols
+	return req
+}
+
+// metadataFor maps a k6 metric onto the Remote-Write 2.0 per-series
+// Metadata message: its type, a help string (the k6 metric name) and, for
+// time-based metrics, a unit.
+func metadataFor(swm *seriesWithMeasure, symbols *symbolTable) writev2.Metadata {
+	md := writev2.Metadata{
+		HelpRef: symbols.ref(swm.Metric.Name),
+	}
+
+	switch swm.Metric.Type {
+	case metrics.Counter:
+		md.Type = writev2.Metadata_METRIC_TYPE_COUNTER
+	case metrics.Gauge:
+		md.Type = writev2.Metadata_METRIC_TYPE_GAUGE
+	case metrics.Trend:
+		if _, ok := swm.Measure.(exponentialHistogram); ok {
+			md.Type = writev2.Metadata_METRIC_TYPE_HISTOGRAM
+		} else {
+			md.Type = writev2.Metadata_METRIC_TYPE_SUMMARY
+		}
+	case metrics.Rate:
+		md.Type = writev2.Metadata_METRIC_TYPE_GAUGE
+	}
+
+	if swm.Metric.Contains == metrics.Time {
+		md.UnitRef = symbols.ref("milliseconds")
+	}
+	return md
+}
@@ -0,0 +1,145 @@
+package remotewrite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/xk6-output-prometheus-remote/pkg/remote"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	prompb "go.buf.build/grpc/go/prometheus/prometheus"
+	"go.k6.io/k6/metrics"
+)
+
+// multiSeriesSink is a PrompbMapSinker stub that maps a single k6 time
+// series onto several prompb.TimeSeries with distinct labels, the way the
+// Trend sink does (one series per stat) and the native histogram sink
+// does (one per bucket). It only exists to exercise convertToPbSeriesV2
+// without depending on those sinks' internals.
+type multiSeriesSink struct{ metrics.Sink }
+
+func (multiSeriesSink) MapPrompb(series metrics.TimeSeries, t time.Time) []*prompb.TimeSeries {
+	mk := func(stat string, value float64) *prompb.TimeSeries {
+		return &prompb.TimeSeries{
+			Labels:  []*prompb.Label{{Name: "__name__", Value: series.Metric.Name + "_" + stat}},
+			Samples: []*prompb.Sample{{Value: value, Timestamp: t.UnixMilli()}},
+		}
+	}
+	return []*prompb.TimeSeries{mk("sum", 1), mk("count", 2), mk("p95", 3)}
+}
+
+// TestConvertToPbSeriesV2PerSeriesLabels is a regression test for a
+// chunk0-1 bug: a metric whose MapPrompb() returns more than one
+// prompb.TimeSeries (every Trend stat comes back as its own entry) was
+// being flattened into a single writev2.TimeSeries carrying only the base
+// series' labels, with every sample crammed into one Samples slice under
+// the same timestamp. Each prompb.TimeSeries must become its own
+// writev2.TimeSeries with its own labels.
+func TestConvertToPbSeriesV2PerSeriesLabels(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m, err := registry.NewMetric("test_trend", metrics.Trend)
+	if err != nil {
+		t.Fatalf("failed to register metric: %v", err)
+	}
+	series := metrics.TimeSeries{Metric: m, Tags: registry.RootTagSet()}
+	swm := &seriesWithMeasure{TimeSeries: series, Measure: multiSeriesSink{}, Latest: time.Now()}
+
+	o := &Output{
+		tsdb:          map[metrics.TimeSeries]*seriesWithMeasure{series: swm},
+		testStartTime: time.Now(),
+	}
+	seen := map[metrics.TimeSeries]struct{}{series: {}}
+
+	want := swm.MapPrompb()
+	req := o.convertToPbSeriesV2(seen, o.testStartTime)
+
+	if len(req.Timeseries) != len(want) {
+		t.Fatalf("expected %d writev2 series (one per MapPrompb series), got %d", len(want), len(req.Timeseries))
+	}
+
+	seenNames := make(map[string]bool)
+	for _, ts := range req.Timeseries {
+		if len(ts.Samples) != 1 {
+			t.Fatalf("expected exactly one sample per writev2 series, got %d", len(ts.Samples))
+		}
+		if len(ts.LabelsRefs) == 0 {
+			t.Fatal("expected each writev2 series to carry its own labels")
+		}
+		name := req.Symbols[ts.LabelsRefs[1]]
+		if seenNames[name] {
+			t.Fatalf("duplicate series name %q: labels were not kept distinct per stat", name)
+		}
+		seenNames[name] = true
+	}
+	if len(seenNames) != 3 {
+		t.Fatalf("expected 3 distinct series names (_sum/_count/p95), got %d: %v", len(seenNames), seenNames)
+	}
+}
+
+// TestSendV2RoutesThroughEndpointMatchersInParallel is a regression test
+// for a chunk0-1 bug: the Remote-Write 2.0 send path called StoreV2
+// directly in a sequential loop, bypassing endpoint.matches entirely, so
+// every endpoint received every tenant's series and a slow endpoint
+// blocked delivery to the rest.
+func TestSendV2RoutesThroughEndpointMatchersInParallel(t *testing.T) {
+	var aHits, bHits int32
+	release := make(chan struct{})
+
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-release
+		atomic.AddInt32(&aHits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srvA.Close()
+
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&bHits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srvB.Close()
+
+	clientA, err := remote.NewWriteClient(srvA.URL, remote.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build client a: %v", err)
+	}
+	clientB, err := remote.NewWriteClient(srvB.URL, remote.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build client b: %v", err)
+	}
+
+	epA := &endpoint{name: "a", client: clientA, matchers: []labelMatcher{{name: "tenant", value: "a"}}}
+	epB := &endpoint{name: "b", client: clientB, matchers: []labelMatcher{{name: "tenant", value: "b"}}}
+
+	req := &writev2.Request{
+		Symbols: []string{"", "tenant", "a", "b"},
+		Timeseries: []writev2.TimeSeries{
+			{LabelsRefs: []uint32{1, 2}}, // tenant=a, blocked on srvA until released
+			{LabelsRefs: []uint32{1, 3}}, // tenant=b
+		},
+	}
+
+	o := &Output{}
+	done := make(chan struct{})
+	go func() {
+		o.sendV2([]*endpoint{epA, epB}, req, nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("sendV2 returned before endpoint a was released, so endpoint b was blocked behind it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if atomic.LoadInt32(&bHits) != 1 {
+		t.Fatal("expected endpoint b to already have received its tenant's series while a was still blocked")
+	}
+
+	close(release)
+	<-done
+
+	if atomic.LoadInt32(&aHits) != 1 {
+		t.Fatal("expected endpoint a to receive exactly its tenant's series")
+	}
+}
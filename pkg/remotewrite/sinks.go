@@ -0,0 +1,84 @@
+package remotewrite
+
+import (
+	"time"
+
+	"go.k6.io/k6/metrics"
+
+	prompb "go.buf.build/grpc/go/prometheus/prometheus"
+)
+
+// PrompbMapSinker is implemented by every metrics.Sink this output knows
+// how to turn into Prometheus TimeSeries. It replaces the Metric.Type
+// switch that used to live in seriesWithMeasure.MapPrompb: once a sink
+// implements it, mapping a series is a single interface call.
+//
+// xk6 extensions can register their own implementation for a metric type
+// via RegisterSinkFactory — for example a t-digest-based quantile sink, a
+// fixed-bucket classic histogram sink emitting `_bucket`/`_sum`/`_count`
+// series with `le` labels, or a UTF-8-label-safe variant — without
+// forking this package.
+type PrompbMapSinker interface {
+	MapPrompb(series metrics.TimeSeries, t time.Time) []*prompb.TimeSeries
+}
+
+// sinkFactories holds the registry populated by RegisterSinkFactory and
+// consulted by newSeriesWithMeasure. It's keyed to build a metrics.Sink,
+// not a PrompbMapSinker, because that's what seriesWithMeasure.Measure
+// actually holds; MapPrompb asserts the PrompbMapSinker side of it at
+// call time, same as it does for the Trend sinks newSeriesWithMeasure builds.
+var sinkFactories = make(map[metrics.MetricType]func(metrics.Metric) metrics.Sink)
+
+// RegisterSinkFactory registers the sink used for series of the given
+// metric type, overriding the built-in one (if any). The returned sink
+// must also implement PrompbMapSinker, or MapPrompb will panic for that
+// metric type. It's meant to be called from an init() func, before any
+// Output is created.
+func RegisterSinkFactory(metricType metrics.MetricType, factory func(metrics.Metric) metrics.Sink) {
+	sinkFactories[metricType] = factory
+}
+
+func init() {
+	RegisterSinkFactory(metrics.Counter, func(metrics.Metric) metrics.Sink {
+		return counterSink{&metrics.CounterSink{}}
+	})
+	RegisterSinkFactory(metrics.Gauge, func(metrics.Metric) metrics.Sink {
+		return gaugeSink{&metrics.GaugeSink{}}
+	})
+	RegisterSinkFactory(metrics.Rate, func(metrics.Metric) metrics.Sink {
+		return rateSink{&metrics.RateSink{}}
+	})
+}
+
+// counterSink, gaugeSink and rateSink wrap the k6 core sinks to add the
+// PrompbMapSinker implementation: the core types live in go.k6.io/k6 and
+// can't have methods added to them directly.
+
+type counterSink struct{ *metrics.CounterSink }
+
+func (s counterSink) MapPrompb(series metrics.TimeSeries, t time.Time) []*prompb.TimeSeries {
+	return []*prompb.TimeSeries{{
+		Labels:  MapSeries(series, ""),
+		Samples: []*prompb.Sample{{Timestamp: t.UnixMilli(), Value: s.Value}},
+	}}
+}
+
+type gaugeSink struct{ *metrics.GaugeSink }
+
+func (s gaugeSink) MapPrompb(series metrics.TimeSeries, t time.Time) []*prompb.TimeSeries {
+	return []*prompb.TimeSeries{{
+		Labels:  MapSeries(series, ""),
+		Samples: []*prompb.Sample{{Timestamp: t.UnixMilli(), Value: s.Value}},
+	}}
+}
+
+type rateSink struct{ *metrics.RateSink }
+
+func (s rateSink) MapPrompb(series metrics.TimeSeries, t time.Time) []*prompb.TimeSeries {
+	// pass zero duration here because time is useless for formatting rate
+	rateVals := s.Format(time.Duration(0))
+	return []*prompb.TimeSeries{{
+		Labels:  MapSeries(series, ""),
+		Samples: []*prompb.Sample{{Timestamp: t.UnixMilli(), Value: rateVals["rate"]}},
+	}}
+}
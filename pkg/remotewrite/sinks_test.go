@@ -0,0 +1,42 @@
+package remotewrite
+
+import (
+	"testing"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+func TestBuiltinSinksMapPrompb(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	for _, tc := range []struct {
+		mtype metrics.MetricType
+		value float64
+	}{
+		{metrics.Counter, 3},
+		{metrics.Gauge, 5},
+		{metrics.Rate, 1},
+	} {
+		m, err := registry.NewMetric(tc.mtype.String(), tc.mtype)
+		if err != nil {
+			t.Fatalf("failed to register metric: %v", err)
+		}
+		series := metrics.TimeSeries{Metric: m, Tags: registry.RootTagSet()}
+
+		factory, ok := sinkFactories[tc.mtype]
+		if !ok {
+			t.Fatalf("no sink factory registered for %s", tc.mtype)
+		}
+		sink := factory(m)
+		sink.Add(metrics.Sample{TimeSeries: series, Time: time.Now(), Value: tc.value})
+
+		ts := sink.(PrompbMapSinker).MapPrompb(series, time.Now())
+		if len(ts) != 1 {
+			t.Fatalf("%s: expected exactly one prompb.TimeSeries, got %d", tc.mtype, len(ts))
+		}
+		if len(ts[0].Samples) != 1 {
+			t.Fatalf("%s: expected exactly one sample, got %d", tc.mtype, len(ts[0].Samples))
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package remotewrite
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+// dropStaleSeries removes from seen any series whose latest sample is
+// older than config.MaxSampleAge, so a delayed flush (network stall,
+// backpressure) doesn't ship samples old enough to fall outside the
+// remote endpoint's out-of-order time window and get the whole request
+// rejected. It's a no-op when MaxSampleAge isn't configured.
+//
+// The two counters it bumps (k6_prw_dropped_old_samples_total and
+// k6_prw_dropped_series_total) are logged by flush on completion.
+func (o *Output) dropStaleSeries(seen map[metrics.TimeSeries]struct{}) {
+	maxAge := o.config.MaxSampleAge.TimeDuration()
+	if maxAge <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	for s := range seen {
+		swm := o.tsdb[s]
+		if swm.Latest.Before(cutoff) {
+			delete(seen, s)
+			atomic.AddUint64(&o.droppedSeries, 1)
+			atomic.AddUint64(&o.droppedOldSamples, 1)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package remotewrite
+
+import (
+	"testing"
+	"time"
+
+	"go.k6.io/k6/lib/types"
+	"go.k6.io/k6/metrics"
+)
+
+func TestDropStaleSeries(t *testing.T) {
+	fresh, freshSeries := newTestSWM(t, metrics.Counter, "fresh")
+	stale, staleSeries := newTestSWM(t, metrics.Counter, "stale")
+	stale.Latest = time.Now().Add(-time.Hour)
+
+	o := &Output{
+		config: Config{MaxSampleAge: types.NewNullDuration(time.Minute, true)},
+		tsdb: map[metrics.TimeSeries]*seriesWithMeasure{
+			freshSeries: fresh,
+			staleSeries: stale,
+		},
+	}
+	seen := map[metrics.TimeSeries]struct{}{freshSeries: {}, staleSeries: {}}
+
+	o.dropStaleSeries(seen)
+
+	if _, ok := seen[freshSeries]; !ok {
+		t.Error("expected the fresh series to remain in seen")
+	}
+	if _, ok := seen[staleSeries]; ok {
+		t.Error("expected the stale series to be dropped from seen")
+	}
+	if got := o.droppedSeries; got != 1 {
+		t.Errorf("droppedSeries = %d, want 1", got)
+	}
+	if got := o.droppedOldSamples; got != 1 {
+		t.Errorf("droppedOldSamples = %d, want 1", got)
+	}
+}
+
+func TestDropStaleSeriesDisabled(t *testing.T) {
+	_, series := newTestSWM(t, metrics.Counter, "whatever")
+	o := &Output{
+		config: Config{MaxSampleAge: types.NullDuration{}},
+		tsdb:   map[metrics.TimeSeries]*seriesWithMeasure{series: {Latest: time.Now().Add(-24 * time.Hour)}},
+	}
+	seen := map[metrics.TimeSeries]struct{}{series: {}}
+
+	o.dropStaleSeries(seen)
+
+	if _, ok := seen[series]; !ok {
+		t.Error("expected dropStaleSeries to be a no-op when MaxSampleAge isn't set")
+	}
+	if o.droppedSeries != 0 {
+		t.Errorf("droppedSeries = %d, want 0", o.droppedSeries)
+	}
+}
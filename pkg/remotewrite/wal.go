@@ -0,0 +1,315 @@
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/grafana/xk6-output-prometheus-remote/pkg/remote"
+
+	"go.k6.io/k6/metrics"
+
+	prompb "go.buf.build/grpc/go/prometheus/prometheus"
+)
+
+const walSegmentExt = ".wal"
+
+// wal is an on-disk, crash-recoverable queue of pending flushes, used in
+// front of the prw1 endpoints so a failed or slow send doesn't lose a
+// flush window: flush() only has to append a segment, while drainWAL owns
+// actually delivering it, with retries.
+type wal struct {
+	dir      string
+	maxBytes int64
+	logger   logrus.FieldLogger
+
+	mu         sync.Mutex
+	segments   []string // ordered oldest-first, absolute paths
+	totalBytes int64
+	nextSeq    uint64
+
+	notify chan struct{}
+
+	droppedSegments uint64
+}
+
+// openWAL opens (creating if needed) the WAL directory and recovers any
+// segments left over from a previous, crashed run so they get retried
+// before new ones.
+func openWAL(dir string, maxBytes int64, logger logrus.FieldLogger) (*wal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create the buffer directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the buffer directory: %w", err)
+	}
+
+	w := &wal{
+		dir:      dir,
+		maxBytes: maxBytes,
+		logger:   logger,
+		notify:   make(chan struct{}, 1),
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), walSegmentExt) {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names) // zero-padded sequence numbers sort lexically
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		w.segments = append(w.segments, path)
+		w.totalBytes += info.Size()
+
+		if seq, err := parseSegmentSeq(name); err == nil && seq >= w.nextSeq {
+			w.nextSeq = seq + 1
+		}
+	}
+
+	if len(names) > 0 {
+		logger.WithField("segments", len(names)).
+			Info("Recovered buffered flushes left over from a previous run")
+	}
+	return w, nil
+}
+
+func parseSegmentSeq(name string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSuffix(name, walSegmentExt), 10, 64)
+}
+
+// enqueue snappy-compresses and appends a WriteRequest as a new segment,
+// evicting the oldest segments first if keeping it would exceed MaxBufferBytes.
+func (w *wal) enqueue(req *prompb.WriteRequest) error {
+	raw, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the buffered write request: %w", err)
+	}
+	compressed := snappy.Encode(nil, raw)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for w.maxBytes > 0 && w.totalBytes+int64(len(compressed)) > w.maxBytes && len(w.segments) > 0 {
+		oldest := w.segments[0]
+		if info, statErr := os.Stat(oldest); statErr == nil {
+			w.totalBytes -= info.Size()
+		}
+		_ = os.Remove(oldest)
+		w.segments = w.segments[1:]
+		atomic.AddUint64(&w.droppedSegments, 1)
+		w.logger.WithField("segment", oldest).
+			Warn("Buffer directory exceeded MaxBufferBytes, dropping the oldest buffered flush")
+	}
+
+	seq := w.nextSeq
+	w.nextSeq++
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, walSegmentExt))
+	if err := os.WriteFile(path, compressed, 0o644); err != nil {
+		return fmt.Errorf("failed to write the buffered flush to disk: %w", err)
+	}
+
+	w.segments = append(w.segments, path)
+	w.totalBytes += int64(len(compressed))
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// peek returns the oldest pending segment's decoded WriteRequest without
+// removing it, or ok=false if the WAL is empty.
+func (w *wal) peek() (path string, req *prompb.WriteRequest, ok bool, err error) {
+	w.mu.Lock()
+	if len(w.segments) == 0 {
+		w.mu.Unlock()
+		return "", nil, false, nil
+	}
+	path = w.segments[0]
+	w.mu.Unlock()
+
+	compressed, err := os.ReadFile(path)
+	if err != nil {
+		return path, nil, true, err
+	}
+	raw, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return path, nil, true, err
+	}
+	req = &prompb.WriteRequest{}
+	if err := proto.Unmarshal(raw, req); err != nil {
+		return path, nil, true, err
+	}
+	return path, req, true, nil
+}
+
+// remove deletes a segment after it's been delivered (or permanently
+// rejected) and drops it from the in-memory index.
+func (w *wal) remove(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if info, err := os.Stat(path); err == nil {
+		w.totalBytes -= info.Size()
+	}
+	_ = os.Remove(path)
+
+	for i, s := range w.segments {
+		if s == path {
+			w.segments = append(w.segments[:i], w.segments[i+1:]...)
+			break
+		}
+	}
+}
+
+const (
+	walMinBackoff = 250 * time.Millisecond
+	walMaxBackoff = 30 * time.Second
+)
+
+// enqueueWAL hands the flushed series off to the WAL instead of sending
+// them directly, so flush() returns as soon as they're durably on disk.
+func (o *Output) enqueueWAL(seen map[metrics.TimeSeries]struct{}) {
+	req := &prompb.WriteRequest{Timeseries: o.convertSeenToPbSeries(seen)}
+	if err := o.wal.enqueue(req); err != nil {
+		o.logger.WithError(err).Error("Failed to buffer the flush to disk")
+	}
+}
+
+// drainWAL runs for the lifetime of the Output, delivering buffered
+// segments to the configured endpoints in order, one at a time, retrying
+// transient failures with exponential backoff and jitter (honoring any
+// Retry-After the endpoint sent). A segment that can't be read back from
+// disk is logged and dropped; any other segment is only dropped once every
+// endpoint has either received it or permanently rejected it (an error
+// remote.Retryable reports as not transient, e.g. a 4xx other than 429):
+// sendWALSegment absorbs a permanent rejection per-endpoint, so one
+// endpoint's 4xx never costs another endpoint its retry.
+func (o *Output) drainWAL(stop chan struct{}) {
+	defer o.walWG.Done()
+
+	backoff := walMinBackoff
+	// delivered tracks, by endpoint name, which endpoints the current
+	// segment has already been successfully sent to, so a retry after a
+	// partial failure doesn't re-send to endpoints that already
+	// succeeded. It's reset whenever the oldest segment changes.
+	var (
+		currentPath string
+		delivered   = make(map[string]bool)
+	)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		path, req, ok, err := o.wal.peek()
+		if err != nil {
+			o.logger.WithError(err).WithField("segment", path).
+				Error("Failed to read a buffered flush, dropping it")
+			o.wal.remove(path)
+			continue
+		}
+		if !ok {
+			select {
+			case <-stop:
+				return
+			case <-o.wal.notify:
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		if path != currentPath {
+			currentPath = path
+			delivered = make(map[string]bool)
+		}
+
+		sendErr := o.sendWALSegment(req, delivered)
+		if sendErr == nil {
+			// Either every endpoint accepted the segment, or the ones
+			// that didn't permanently rejected it and sendWALSegment
+			// already gave up on them; nothing is left to retry.
+			o.wal.remove(path)
+			backoff = walMinBackoff
+			continue
+		}
+
+		wait := backoff
+		if we, ok := sendErr.(*remote.WriteError); ok && we.RetryAfter > 0 {
+			wait = we.RetryAfter
+		} else {
+			wait += time.Duration(rand.Int63n(int64(wait) + 1)) // jitter
+		}
+		o.logger.WithError(sendErr).WithField("retry_in", wait).
+			Warn("Failed to send a buffered flush, will retry")
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > walMaxBackoff {
+			backoff = walMaxBackoff
+		}
+	}
+}
+
+// sendWALSegment fans a buffered WriteRequest out to every configured
+// endpoint not already marked as delivered, recording each newly
+// successful send in delivered so a caller retrying after a partial
+// failure only resends to the endpoints that are still pending.
+//
+// An endpoint whose error is a permanent rejection is also marked
+// delivered: the segment is done with it, but that alone must not decide
+// the fate of any other endpoint that's still genuinely pending. So the
+// returned error, if any, is one of the still-retryable endpoints'
+// errors; the caller only has something left to retry when this is
+// non-nil, and can safely drop the whole segment once it's nil even if
+// some endpoints only ever got a permanent rejection.
+func (o *Output) sendWALSegment(req *prompb.WriteRequest, delivered map[string]bool) error {
+	var retryableErr error
+	for _, ep := range o.endpoints {
+		if delivered[ep.name] {
+			continue
+		}
+		err := ep.store(context.Background(), req.Timeseries)
+		if err == nil {
+			delivered[ep.name] = true
+			continue
+		}
+		if !remote.Retryable(err) {
+			o.logger.WithError(err).WithField("endpoint", ep.name).
+				Error("Buffered flush was permanently rejected for this endpoint, giving up on it")
+			delivered[ep.name] = true
+			continue
+		}
+		retryableErr = err
+	}
+	return retryableErr
+}
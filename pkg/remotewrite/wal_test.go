@@ -0,0 +1,146 @@
+package remotewrite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/xk6-output-prometheus-remote/pkg/remote"
+	"github.com/sirupsen/logrus"
+	prompb "go.buf.build/grpc/go/prometheus/prometheus"
+)
+
+// TestSendWALSegmentSkipsDeliveredEndpoints is a regression test for a
+// chunk0-5 bug: sendWALSegment treated a multi-endpoint fanout as
+// all-or-nothing, so retrying a segment after one endpoint failed also
+// re-sent it to endpoints that had already succeeded.
+func TestSendWALSegmentSkipsDeliveredEndpoints(t *testing.T) {
+	var okHits, failHits int64
+
+	okSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt64(&okHits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer okSrv.Close()
+
+	failSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt64(&failHits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failSrv.Close()
+
+	okClient, err := remote.NewWriteClient(okSrv.URL, remote.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build the ok client: %v", err)
+	}
+	failClient, err := remote.NewWriteClient(failSrv.URL, remote.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build the failing client: %v", err)
+	}
+
+	o := &Output{endpoints: []*endpoint{
+		{name: "ok", client: okClient},
+		{name: "fail", client: failClient},
+	}}
+
+	req := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+		Labels:  []*prompb.Label{{Name: "__name__", Value: "test"}},
+		Samples: []*prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}}
+
+	delivered := make(map[string]bool)
+
+	if err := o.sendWALSegment(req, delivered); err == nil {
+		t.Fatal("expected an error from the failing endpoint on the first attempt")
+	}
+	if !delivered["ok"] {
+		t.Fatal("expected the ok endpoint to be marked delivered after succeeding")
+	}
+	if delivered["fail"] {
+		t.Fatal("the failing endpoint must not be marked delivered")
+	}
+
+	if err := o.sendWALSegment(req, delivered); err == nil {
+		t.Fatal("expected the retry to still report an error")
+	}
+
+	if got := atomic.LoadInt64(&okHits); got != 1 {
+		t.Fatalf("ok endpoint was hit %d times, want 1 (already delivered, must not be resent)", got)
+	}
+	if got := atomic.LoadInt64(&failHits); got != 2 {
+		t.Fatalf("failing endpoint was hit %d times, want 2", got)
+	}
+}
+
+// TestSendWALSegmentDoesNotDropSegmentForStillRetryableEndpoint is a
+// regression test for a chunk0-5 bug: sendWALSegment's single returned
+// error keyed drainWAL's drop-or-retry decision off whichever endpoint
+// happened to fail first. With a permanent 4xx from one endpoint and a
+// transient 5xx from another, that meant a permanent rejection on the
+// first endpoint made drainWAL drop the segment for the still-retryable
+// second endpoint too.
+func TestSendWALSegmentDoesNotDropSegmentForStillRetryableEndpoint(t *testing.T) {
+	var permanentHits, transientHits int64
+
+	permanentSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt64(&permanentHits, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer permanentSrv.Close()
+
+	transientSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt64(&transientHits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer transientSrv.Close()
+
+	permanentClient, err := remote.NewWriteClient(permanentSrv.URL, remote.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build the permanently-failing client: %v", err)
+	}
+	transientClient, err := remote.NewWriteClient(transientSrv.URL, remote.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("failed to build the transiently-failing client: %v", err)
+	}
+
+	o := &Output{
+		logger: logrus.New(),
+		endpoints: []*endpoint{
+			{name: "permanent", client: permanentClient},
+			{name: "transient", client: transientClient},
+		},
+	}
+
+	req := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+		Labels:  []*prompb.Label{{Name: "__name__", Value: "test"}},
+		Samples: []*prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}}
+
+	delivered := make(map[string]bool)
+
+	err = o.sendWALSegment(req, delivered)
+	if err == nil {
+		t.Fatal("expected an error: the transient endpoint is still pending")
+	}
+	if !remote.Retryable(err) {
+		t.Fatalf("expected a retryable error even though one endpoint failed permanently, got %v", err)
+	}
+	if !delivered["permanent"] {
+		t.Fatal("expected the permanently-rejecting endpoint to be given up on (marked delivered)")
+	}
+	if delivered["transient"] {
+		t.Fatal("the transiently-failing endpoint must not be marked delivered")
+	}
+
+	if err := o.sendWALSegment(req, delivered); err == nil {
+		t.Fatal("expected the retry to still report an error from the transient endpoint")
+	}
+
+	if got := atomic.LoadInt64(&permanentHits); got != 1 {
+		t.Fatalf("permanently-rejecting endpoint was hit %d times, want 1 (given up after its first rejection)", got)
+	}
+	if got := atomic.LoadInt64(&transientHits); got != 2 {
+		t.Fatalf("transiently-failing endpoint was hit %d times, want 2", got)
+	}
+}